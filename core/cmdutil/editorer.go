@@ -3,6 +3,7 @@ package cmdutil
 import (
 	"github.com/jmigpin/editor/core/toolbardata"
 	"github.com/jmigpin/editor/ui"
+	"github.com/jmigpin/editor/util/uiutil/event"
 )
 
 type Editorer interface {
@@ -18,4 +19,11 @@ type Editorer interface {
 	GoodColumnRowPlace() (col *ui.Column, next *ui.Row)
 
 	HomeVars() *toolbardata.HomeVars
+
+	// GetCPPaste and SetCPCopy read and publish clipboard/primary-selection
+	// contents through whichever driver.Window backs the running editor,
+	// so callers get the same behavior regardless of which driver (wayland,
+	// xdriver, ...) is active.
+	GetCPPaste(i event.CopyPasteIndex) (string, error)
+	SetCPCopy(i event.CopyPasteIndex, s string) error
 }
@@ -0,0 +1,178 @@
+package gosource
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Scope controls how far FindReferences looks for other identifiers
+// resolving to the same declaration.
+type Scope int
+
+const (
+	ScopeFile Scope = iota
+	ScopePackage
+	ScopeModule
+)
+
+// FindReferences is the symmetric counterpart to DeclPosition: it resolves
+// the identifier at index to its declaration, then walks every file in
+// scope looking for other identifiers whose ResolveDecl points at that same
+// node. Positions are deduplicated by (filename, offset) and returned
+// sorted by filename then offset.
+func FindReferences(filename string, src interface{}, index int, scope Scope) ([]token.Position, error) {
+	info := NewInfo()
+
+	// parse main file
+	filename = info.AddPathFile(filename)
+	astFile := info.ParseFile(filename, src)
+	if astFile == nil {
+		return nil, fmt.Errorf("unable to parse file")
+	}
+
+	// get index node
+	tokenFile := info.FSet.File(astFile.Package)
+	if tokenFile == nil {
+		return nil, fmt.Errorf("unable to get token file")
+	}
+	indexNode := info.PosNode(info.SafeOffsetPos(tokenFile, index))
+	if indexNode == nil {
+		return nil, fmt.Errorf("index node not found")
+	}
+
+	// must be an id
+	id, ok := indexNode.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("index not at an id node")
+	}
+
+	// resolve id declaration
+	path := info.PosFilePath(astFile.Package)
+	res := NewResolver(info, path, id)
+	decl := res.ResolveDecl(id)
+	if decl == nil {
+		return nil, fmt.Errorf("id decl not found")
+	}
+
+	files, err := referenceFiles(filename, path, scope)
+	if err != nil {
+		return nil, err
+	}
+	// always include the starting file, in case it's an unsaved buffer
+	// (src != nil) not reflected on disk yet.
+	files[filename] = struct{}{}
+
+	seen := map[string]bool{}
+	var out []token.Position
+	for fname := range files {
+		// the starting file is already parsed; reuse it instead of
+		// parsing it a second time (it may be an unsaved buffer with
+		// no on-disk counterpart to re-read).
+		f := astFile
+		if fname != filename {
+			f = info.ParseFile(info.AddPathFile(fname), nil)
+			if f == nil {
+				continue
+			}
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			id2, ok := n.(*ast.Ident)
+			if !ok || id2.Name == "_" {
+				return true
+			}
+			res2 := NewResolver(info, info.PosFilePath(id2.Pos()), id2)
+			if res2.ResolveDecl(id2) != decl {
+				return true
+			}
+			p := info.FSet.Position(id2.Pos())
+			key := fmt.Sprintf("%s:%d", p.Filename, p.Offset)
+			if seen[key] {
+				return true
+			}
+			seen[key] = true
+			out = append(out, p)
+			return true
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Filename != out[j].Filename {
+			return out[i].Filename < out[j].Filename
+		}
+		return out[i].Offset < out[j].Offset
+	})
+
+	return out, nil
+}
+
+// referenceFiles lists the absolute paths of the .go files FindReferences
+// should inspect for scope, rooted at the identifier's own package path.
+func referenceFiles(filename, pkgPath string, scope Scope) (map[string]struct{}, error) {
+	files := map[string]struct{}{}
+	switch scope {
+	case ScopeFile:
+		files[filename] = struct{}{}
+	case ScopePackage:
+		if err := addGoFilesInDir(pkgPath, files); err != nil {
+			return nil, err
+		}
+	case ScopeModule:
+		root := moduleRoot(pkgPath)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if d.Name() != "." && len(d.Name()) > 0 && d.Name()[0] == '.' {
+				return filepath.SkipDir
+			}
+			if d.Name() == "vendor" {
+				return filepath.SkipDir
+			}
+			return addGoFilesInDir(p, files)
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown scope %v", scope)
+	}
+	return files, nil
+}
+
+func addGoFilesInDir(dir string, files map[string]struct{}) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".go" {
+			continue
+		}
+		files[filepath.Join(dir, e.Name())] = struct{}{}
+	}
+	return nil
+}
+
+// moduleRoot walks up from dir looking for a go.mod, falling back to dir
+// itself if none is found.
+func moduleRoot(dir string) string {
+	d := dir
+	for {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
@@ -0,0 +1,24 @@
+package gosource
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindReferencesScopeFile(t *testing.T) {
+	src := `package foo
+
+func bar() int {
+	x := 1
+	return x + x
+}
+`
+	index := strings.Index(src, "x :=")
+	positions, err := FindReferences("t000/src.go", src, index, ScopeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 3 {
+		t.Fatalf("expected 3 references to x, got %v: %v", len(positions), positions)
+	}
+}
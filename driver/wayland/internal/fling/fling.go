@@ -0,0 +1,166 @@
+// Package fling implements kinetic/inertial scrolling for wl_pointer axis
+// events: it buffers recent deltas, fits an initial velocity once the
+// compositor reports axis_stop, and then emits a decaying stream of
+// synthetic scroll deltas.
+package fling
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTau is the exponential velocity decay time constant, chosen to
+	// match the deceleration feel of common touchpad drivers.
+	DefaultTau = 325 * time.Millisecond
+
+	sampleWindow     = 100 * time.Millisecond
+	tickInterval     = 16 * time.Millisecond // ~60Hz
+	minPxPerTickRate = 1.0 / float64(tickInterval) * float64(time.Second)
+)
+
+type sample struct {
+	t      time.Time
+	dx, dy float64
+}
+
+// Tracker accumulates timestamped axis deltas into a short ring buffer and
+// fits an initial velocity (px/s per axis) from the last ~100ms of them.
+type Tracker struct {
+	mu      sync.Mutex
+	samples []sample
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// Add records a new axis delta at time now, dropping samples older than
+// sampleWindow.
+func (t *Tracker) Add(dx, dy float64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples = append(t.samples, sample{t: now, dx: dx, dy: dy})
+	cutoff := now.Add(-sampleWindow)
+	i := 0
+	for i < len(t.samples) && t.samples[i].t.Before(cutoff) {
+		i++
+	}
+	t.samples = t.samples[i:]
+}
+
+// Reset discards all buffered samples.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples = nil
+}
+
+// Velocity fits a line (ordinary least squares) to the cumulative
+// displacement of the buffered samples against time, for each axis, and
+// returns its slope in pixels/second.
+func (t *Tracker) Velocity() (vx, vy float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < 2 {
+		return 0, 0
+	}
+
+	t0 := t.samples[0].t
+	xs := make([]float64, len(t.samples))
+	cumX := make([]float64, len(t.samples))
+	cumY := make([]float64, len(t.samples))
+	var ax, ay float64
+	for i, s := range t.samples {
+		xs[i] = s.t.Sub(t0).Seconds()
+		ax += s.dx
+		ay += s.dy
+		cumX[i] = ax
+		cumY[i] = ay
+	}
+
+	return leastSquaresSlope(xs, cumX), leastSquaresSlope(xs, cumY)
+}
+
+func leastSquaresSlope(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	denom := n*sxx - sx*sx
+	if denom == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / denom
+}
+
+// Fling is a running kinetic-scroll animation; Stop cancels it early.
+type Fling struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Start spawns a goroutine that calls onTick with whole-pixel deltas at
+// ~60Hz, decaying (vx, vy) exponentially with time constant tau, until the
+// remaining speed drops under one pixel per tick. Returns nil if the
+// initial velocity is already negligible, in which case there's nothing to
+// animate.
+func Start(vx, vy float64, tau time.Duration, onTick func(dx, dy int)) *Fling {
+	if math.Hypot(vx, vy) < minPxPerTickRate {
+		return nil
+	}
+
+	f := &Fling{stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+
+		var carryX, carryY float64 // fractional pixel carry so deltas don't round away
+		last := time.Now()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case now := <-ticker.C:
+				dt := now.Sub(last)
+				last = now
+
+				decay := math.Exp(-dt.Seconds() / tau.Seconds())
+				vx *= decay
+				vy *= decay
+
+				carryX += vx * dt.Seconds()
+				carryY += vy * dt.Seconds()
+				dx, dy := int(carryX), int(carryY)
+				carryX -= float64(dx)
+				carryY -= float64(dy)
+
+				if dx != 0 || dy != 0 {
+					onTick(dx, dy)
+				}
+				if math.Hypot(vx, vy) < minPxPerTickRate {
+					return
+				}
+			}
+		}
+	}()
+	return f
+}
+
+// Stop cancels the fling early, e.g. on a new button press or axis event.
+// It is safe to call on a nil *Fling or call more than once.
+func (f *Fling) Stop() {
+	if f == nil {
+		return
+	}
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
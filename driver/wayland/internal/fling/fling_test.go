@@ -0,0 +1,60 @@
+package fling
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeastSquaresSlope(t *testing.T) {
+	xs := []float64{0, 1, 2, 3}
+	ys := []float64{0, 2, 4, 6}
+	got := leastSquaresSlope(xs, ys)
+	if got != 2 {
+		t.Fatalf("expected slope 2, got %v", got)
+	}
+}
+
+func TestLeastSquaresSlopeConstantX(t *testing.T) {
+	xs := []float64{1, 1, 1}
+	ys := []float64{0, 5, 10}
+	got := leastSquaresSlope(xs, ys)
+	if got != 0 {
+		t.Fatalf("expected slope 0 for degenerate input, got %v", got)
+	}
+}
+
+func TestTrackerVelocity(t *testing.T) {
+	tr := NewTracker()
+	t0 := time.Unix(0, 0)
+	tr.Add(10, 0, t0)
+	tr.Add(10, 0, t0.Add(10*time.Millisecond))
+	tr.Add(10, 0, t0.Add(20*time.Millisecond))
+
+	vx, vy := tr.Velocity()
+	if vx <= 0 {
+		t.Fatalf("expected positive x velocity, got %v", vx)
+	}
+	if vy != 0 {
+		t.Fatalf("expected zero y velocity, got %v", vy)
+	}
+}
+
+func TestTrackerVelocityInsufficientSamples(t *testing.T) {
+	tr := NewTracker()
+	tr.Add(10, 10, time.Unix(0, 0))
+	vx, vy := tr.Velocity()
+	if vx != 0 || vy != 0 {
+		t.Fatalf("expected zero velocity with a single sample, got (%v, %v)", vx, vy)
+	}
+}
+
+func TestTrackerReset(t *testing.T) {
+	tr := NewTracker()
+	tr.Add(10, 0, time.Unix(0, 0))
+	tr.Add(10, 0, time.Unix(0, 0).Add(10*time.Millisecond))
+	tr.Reset()
+	vx, vy := tr.Velocity()
+	if vx != 0 || vy != 0 {
+		t.Fatalf("expected zero velocity after Reset, got (%v, %v)", vx, vy)
+	}
+}
@@ -0,0 +1,133 @@
+// Package xkb wraps libxkbcommon so the Wayland driver can turn the keymap
+// blob handed over by the compositor (wl_keyboard.keymap) into actual
+// keysyms and UTF-8 text, and keep track of modifier state as
+// wl_keyboard.modifiers events come in.
+package xkb
+
+// #cgo pkg-config: xkbcommon
+// #include <stdlib.h>
+// #include <xkbcommon/xkbcommon.h>
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// KeySym mirrors xkb_keysym_t; values match the XKB/X11 keysym space that
+// the xdriver already speaks, so callers can convert straight into
+// event.KeySym.
+type KeySym uint32
+
+// Context wraps an xkb_context.
+type Context struct {
+	ctx *C.struct_xkb_context
+}
+
+// NewContext creates a new xkb_context with no special flags.
+func NewContext() (*Context, error) {
+	ctx := C.xkb_context_new(C.XKB_CONTEXT_NO_FLAGS)
+	if ctx == nil {
+		return nil, errors.New("xkb: unable to create context")
+	}
+	return &Context{ctx: ctx}, nil
+}
+
+// Close releases the underlying xkb_context.
+func (c *Context) Close() {
+	if c.ctx != nil {
+		C.xkb_context_unref(c.ctx)
+		c.ctx = nil
+	}
+}
+
+// Keymap wraps an xkb_keymap compiled from the XKB_V1 text sent by the
+// compositor.
+type Keymap struct {
+	keymap *C.struct_xkb_keymap
+}
+
+// NewKeymapFromString compiles a null-terminated XKB_V1 keymap string, as
+// received (mmap'd) from wl_keyboard.keymap.
+func (c *Context) NewKeymapFromString(s string) (*Keymap, error) {
+	cs := C.CString(s)
+	defer C.free(unsafe.Pointer(cs))
+
+	keymap := C.xkb_keymap_new_from_string(c.ctx, cs, C.XKB_KEYMAP_FORMAT_TEXT_V1, C.XKB_KEYMAP_COMPILE_NO_FLAGS)
+	if keymap == nil {
+		return nil, errors.New("xkb: unable to compile keymap")
+	}
+	return &Keymap{keymap: keymap}, nil
+}
+
+// Close releases the underlying xkb_keymap.
+func (k *Keymap) Close() {
+	if k.keymap != nil {
+		C.xkb_keymap_unref(k.keymap)
+		k.keymap = nil
+	}
+}
+
+// KeyRepeats reports whether the given evdev keycode should auto-repeat
+// (modifier keys and the like don't).
+func (k *Keymap) KeyRepeats(evdevCode uint32) bool {
+	return C.xkb_keymap_key_repeats(k.keymap, xkbKeycode(evdevCode)) == 1
+}
+
+// NewState creates an xkb_state tracking modifier/group state for this
+// keymap.
+func (k *Keymap) NewState() *State {
+	return &State{state: C.xkb_state_new(k.keymap)}
+}
+
+// State wraps an xkb_state.
+type State struct {
+	state *C.struct_xkb_state
+}
+
+// Close releases the underlying xkb_state.
+func (s *State) Close() {
+	if s.state != nil {
+		C.xkb_state_unref(s.state)
+		s.state = nil
+	}
+}
+
+// UpdateMask feeds a wl_keyboard.modifiers event into the xkb state.
+func (s *State) UpdateMask(depressed, latched, locked, group uint32) {
+	C.xkb_state_update_mask(s.state,
+		C.xkb_mod_mask_t(depressed), C.xkb_mod_mask_t(latched), C.xkb_mod_mask_t(locked),
+		0, 0, C.xkb_layout_index_t(group))
+}
+
+// xkbKeycode converts a Linux evdev keycode (as sent in wl_keyboard.key)
+// into an xkb keycode, which is offset by 8.
+func xkbKeycode(evdevCode uint32) C.xkb_keycode_t {
+	return C.xkb_keycode_t(evdevCode + 8)
+}
+
+// KeySym returns the keysym the given evdev keycode currently maps to,
+// given the tracked modifier state.
+func (s *State) KeySym(evdevCode uint32) KeySym {
+	return KeySym(C.xkb_state_key_get_one_sym(s.state, xkbKeycode(evdevCode)))
+}
+
+// UTF8 returns the UTF-8 text the given evdev keycode produces, given the
+// tracked modifier state. It is empty for non-printable keys.
+func (s *State) UTF8(evdevCode uint32) string {
+	n := C.xkb_state_key_get_utf8(s.state, xkbKeycode(evdevCode), nil, 0)
+	if n <= 0 {
+		return ""
+	}
+	buf := make([]byte, n+1)
+	C.xkb_state_key_get_utf8(s.state, xkbKeycode(evdevCode), (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)))
+	return string(buf[:n])
+}
+
+// ModNameIsActive reports whether the named modifier (e.g. "Shift",
+// "Control", "Mod1") is currently effective.
+func (s *State) ModNameIsActive(name string) bool {
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+	return C.xkb_state_mod_name_is_active(s.state, cs, C.XKB_STATE_MODS_EFFECTIVE) > 0
+}
@@ -4,21 +4,49 @@ import (
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"io"
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/jmigpin/editor/driver"
+	"github.com/jmigpin/editor/driver/wayland/internal/fling"
 	"github.com/jmigpin/editor/driver/wayland/internal/swizzle"
+	"github.com/jmigpin/editor/driver/wayland/internal/xkb"
 	xdriver "github.com/jmigpin/editor/driver/xdriver"
 	"github.com/jmigpin/editor/util/uiutil/event"
 	"github.com/nfnt/resize"
 	"github.com/rajveermalviya/go-wayland/wayland/client"
 	"github.com/rajveermalviya/go-wayland/wayland/cursor"
 	xdg_shell "github.com/rajveermalviya/go-wayland/wayland/stable/xdg-shell"
+	primary_selection "github.com/rajveermalviya/go-wayland/wayland/unstable/primary-selection-unstable-v1"
+	text_input "github.com/rajveermalviya/go-wayland/wayland/unstable/text-input-unstable-v3"
+	zxdg_decoration "github.com/rajveermalviya/go-wayland/wayland/unstable/xdg-decoration-unstable-v1"
 	"golang.org/x/sys/unix"
 )
 
+// csdTitlebarHeight and csdBorder size the minimal client-side decorations
+// drawn when the compositor refuses server_side mode. csdCloseButtonSize
+// and csdButtonMargin size the titlebar's close button, the one affordance
+// this fallback needs since there's otherwise no way to close such a
+// window from the UI.
+const (
+	csdTitlebarHeight  = 28
+	csdBorder          = 6
+	csdCloseButtonSize = 16
+	csdButtonMargin    = 6
+)
+
 type Window struct {
+	// mu guards every field below that's read or written from both the
+	// eventLoop dispatch goroutine (protocol handlers) and the application
+	// goroutine that calls Request/GetCPPaste/SetCPCopy/SetInputCursorRect/
+	// SetInputSurroundingText/Close per the driver.Window contract.
+	mu sync.Mutex
+
 	exit          bool
 	width, height int32
 
@@ -42,6 +70,58 @@ type Window struct {
 	// pointerEvent pointerEvent
 	cursorTheme *cursor.Theme
 	// currentCursor *cursorData
+
+	events     chan event.Event
+	pointerPos image.Point
+	lastSerial uint32
+
+	xkbContext *xkb.Context
+	keymap     *xkb.Keymap
+	xkbState   *xkb.State
+
+	repeatRate  int32
+	repeatDelay int32
+	repeatKey   uint32
+	repeatStop  chan struct{}
+
+	dataDeviceManager *client.DataDeviceManager
+	dataDevice        *client.DataDevice
+	clipboardOffer    *client.DataOffer
+
+	primarySelectionManager *primary_selection.DeviceManager
+	primaryDevice           *primary_selection.Device
+	primaryOffer            *primary_selection.Offer
+
+	decorationManager  *zxdg_decoration.DecorationManager
+	toplevelDecoration *zxdg_decoration.ToplevelDecoration
+	serverDecorated    bool
+	csdTitlebarDrawn   bool
+
+	axisTracker *fling.Tracker
+	curFling    *fling.Fling
+
+	textInputManager *text_input.Manager
+	textInput        *text_input.TextInput
+	focused          bool
+
+	surroundingText     string
+	surroundingCursor   int32
+	surroundingAnchor   int32
+	cursorRect          image.Rectangle
+	pendingPreedit      *event.PreeditString
+	pendingCommit       *string
+	pendingDeleteBefore uint32
+	pendingDeleteAfter  uint32
+
+	bufPool []*shmBuffer
+	dirty   []image.Rectangle
+	// bufFree wakes a paint() blocked in acquireBuffer, waiting for the
+	// compositor to release a buffer, once one does (or the window starts
+	// closing). Backed by mu, since both sides already need it held.
+	bufFree *sync.Cond
+
+	pendingWidth  int32
+	pendingHeight int32
 }
 
 func tempfileCreate(size int64) (*os.File, error) {
@@ -79,12 +159,64 @@ func (self *Window) releaseKeyboard() {
 }
 
 func (self *Window) Close() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// Wake any paint() blocked in acquireBuffer waiting on a buffer
+	// release: it must see exit and bail out instead of reaching for the
+	// pool or shm object this is about to tear down.
+	self.exit = true
+	self.bufFree.Broadcast()
+
+	self.stopKeyRepeat()
+	self.curFling.Stop()
+	self.curFling = nil
+	for _, b := range self.bufPool {
+		b.destroy()
+	}
+	self.bufPool = nil
+	if self.textInput != nil {
+		if err := self.textInput.Destroy(); err != nil {
+			log.Println("unable to destroy zwp_text_input_v3:", err)
+		}
+		self.textInput = nil
+	}
+	if self.toplevelDecoration != nil {
+		if err := self.toplevelDecoration.Destroy(); err != nil {
+			log.Println("unable to destroy zxdg_toplevel_decoration_v1:", err)
+		}
+		self.toplevelDecoration = nil
+	}
+	if self.primaryDevice != nil {
+		if err := self.primaryDevice.Destroy(); err != nil {
+			log.Println("unable to destroy zwp_primary_selection_device_v1:", err)
+		}
+		self.primaryDevice = nil
+	}
+	if self.dataDevice != nil {
+		if err := self.dataDevice.Release(); err != nil {
+			log.Println("unable to release wl_data_device:", err)
+		}
+		self.dataDevice = nil
+	}
 	if self.pointer != nil {
 		self.releasePointer()
 	}
 	if self.keyboard != nil {
 		self.releaseKeyboard()
 	}
+	if self.xkbState != nil {
+		self.xkbState.Close()
+		self.xkbState = nil
+	}
+	if self.keymap != nil {
+		self.keymap.Close()
+		self.keymap = nil
+	}
+	if self.xkbContext != nil {
+		self.xkbContext.Close()
+		self.xkbContext = nil
+	}
 	if self.cursorTheme != nil {
 		if err := self.cursorTheme.Destroy(); err != nil {
 			log.Println("unable to destroy cursor theme:", err)
@@ -164,27 +296,147 @@ func (self *Window) handleWmBasePing(e xdg_shell.WmBasePingEvent) {
 	}
 }
 
+// linux/input-event-codes.h button codes sent by wl_pointer.button.
+const (
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+)
+
+func waylandButton(code uint32) event.Button {
+	switch code {
+	case btnLeft:
+		return event.ButtonLeft
+	case btnRight:
+		return event.ButtonRight
+	case btnMiddle:
+		return event.ButtonMiddle
+	default:
+		return event.ButtonNone
+	}
+}
+
+func fixedToInt(f client.Fixed) int {
+	return int(f.ToFloat64())
+}
+
+func (self *Window) pushEvent(ev event.Event) {
+	self.events <- ev
+}
+
+func (self *Window) handlePointerEnter(e client.PointerEnterEvent) {
+	self.mu.Lock()
+	self.lastSerial = e.Serial
+	self.pointerPos = image.Point{X: fixedToInt(e.SurfaceX), Y: fixedToInt(e.SurfaceY)}
+	p := self.pointerPos
+	self.mu.Unlock()
+	self.pushEvent(&event.MouseEnter{Point: p})
+}
+
+func (self *Window) handlePointerLeave(e client.PointerLeaveEvent) {
+	self.mu.Lock()
+	self.lastSerial = e.Serial
+	p := self.pointerPos
+	self.mu.Unlock()
+	self.pushEvent(&event.MouseLeave{Point: p})
+}
+
+func (self *Window) handlePointerMotion(e client.PointerMotionEvent) {
+	self.pointerPos = image.Point{X: fixedToInt(e.SurfaceX), Y: fixedToInt(e.SurfaceY)}
+	self.pushEvent(&event.MouseMove{Point: self.pointerPos})
+}
+
+func (self *Window) handlePointerButton(e client.PointerButtonEvent) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.lastSerial = e.Serial
+	self.curFling.Stop()
+	self.curFling = nil
+
+	btn := waylandButton(e.Button)
+	if btn == event.ButtonNone {
+		return
+	}
+	pressed := e.State == uint32(client.PointerButtonStatePressed)
+	if pressed && btn == event.ButtonLeft && self.handleCSDPress() {
+		return
+	}
+	if pressed {
+		self.pushEvent(&event.MouseDown{Point: self.pointerPos, Button: btn})
+	} else {
+		self.pushEvent(&event.MouseUp{Point: self.pointerPos, Button: btn})
+	}
+}
+
+func (self *Window) handlePointerAxis(e client.PointerAxisEvent) {
+	self.curFling.Stop()
+	self.curFling = nil
+
+	delta := image.Point{}
+	switch client.PointerAxis(e.Axis) {
+	case client.PointerAxisVerticalScroll:
+		delta.Y = fixedToInt(e.Value)
+	case client.PointerAxisHorizontalScroll:
+		delta.X = fixedToInt(e.Value)
+	}
+	if delta == (image.Point{}) {
+		return
+	}
+
+	if self.axisTracker != nil {
+		self.axisTracker.Add(float64(delta.X), float64(delta.Y), time.Now())
+	}
+
+	self.pushEvent(&event.MouseWheel{Point: self.pointerPos, Delta: delta})
+}
+
+func (self *Window) handlePointerAxisSource(e client.PointerAxisSourceEvent) {
+	if self.axisTracker == nil {
+		self.axisTracker = fling.NewTracker()
+	}
+	self.axisTracker.Reset()
+}
+
+// handlePointerAxisStop fits an initial velocity from the buffered axis
+// samples and kicks off a decaying fling animation that synthesizes further
+// MouseWheel events after the user's finger leaves the touchpad.
+func (self *Window) handlePointerAxisStop(_ client.PointerAxisStopEvent) {
+	if self.axisTracker == nil {
+		return
+	}
+	vx, vy := self.axisTracker.Velocity()
+	self.axisTracker.Reset()
+
+	self.curFling.Stop()
+	self.curFling = fling.Start(vx, vy, fling.DefaultTau, func(dx, dy int) {
+		self.pushEvent(&event.MouseWheel{Point: self.pointerPos, Delta: image.Point{X: dx, Y: dy}})
+	})
+}
+
 func (self *Window) attachPointer() {
 	pointer, err := self.seat.GetPointer()
 	if err != nil {
 		log.Fatal("unable to register pointer interface:", err)
 	}
 	self.pointer = pointer
-	// TODO:
-	// pointer.SetEnterHandler(self.HandlePointerEnter)
-	// pointer.SetLeaveHandler(self.HandlePointerLeave)
-	// pointer.SetMotionHandler(self.HandlePointerMotion)
-	// pointer.SetButtonHandler(self.HandlePointerButton)
-	// pointer.SetAxisHandler(self.HandlePointerAxis)
-	// pointer.SetAxisSourceHandler(self.HandlePointerAxisSource)
-	// pointer.SetAxisStopHandler(self.HandlePointerAxisStop)
-	// pointer.SetAxisDiscreteHandler(self.HandlePointerAxisDiscrete)
-	// pointer.SetFrameHandler(self.HandlePointerFrame)
+	pointer.SetEnterHandler(self.handlePointerEnter)
+	pointer.SetLeaveHandler(self.handlePointerLeave)
+	pointer.SetMotionHandler(self.handlePointerMotion)
+	pointer.SetButtonHandler(self.handlePointerButton)
+	pointer.SetAxisHandler(self.handlePointerAxis)
+	pointer.SetAxisSourceHandler(self.handlePointerAxisSource)
+	pointer.SetAxisStopHandler(self.handlePointerAxisStop)
 }
 
 func (self *Window) handleKeyboardKeymap(e client.KeyboardKeymapEvent) {
 	defer unix.Close(e.Fd)
 
+	if client.KeyboardKeymapFormat(e.Format) != client.KeyboardKeymapFormatXkbV1 {
+		log.Printf("wayland: unsupported keymap format: %v\n", e.Format)
+		return
+	}
+
 	flags := unix.MAP_SHARED
 	if self.seatVersion >= 7 {
 		flags = unix.MAP_PRIVATE
@@ -196,11 +448,145 @@ func (self *Window) handleKeyboardKeymap(e client.KeyboardKeymapEvent) {
 		return
 	}
 	defer unix.Munmap(buf)
-	fmt.Println(string(buf))
+
+	if self.xkbContext == nil {
+		ctx, err := xkb.NewContext()
+		if err != nil {
+			log.Println("xkb:", err)
+			return
+		}
+		self.xkbContext = ctx
+	}
+
+	// buf is NUL-terminated per the wl_keyboard.keymap XKB_V1 contract.
+	keymap, err := self.xkbContext.NewKeymapFromString(unix.ByteSliceToString(buf))
+	if err != nil {
+		log.Println("xkb:", err)
+		return
+	}
+
+	if self.xkbState != nil {
+		self.xkbState.Close()
+	}
+	if self.keymap != nil {
+		self.keymap.Close()
+	}
+	self.keymap = keymap
+	self.xkbState = keymap.NewState()
+}
+
+func (self *Window) handleKeyboardModifiers(e client.KeyboardModifiersEvent) {
+	if self.xkbState == nil {
+		return
+	}
+	self.xkbState.UpdateMask(e.ModsDepressed, e.ModsLatched, e.ModsLocked, e.Group)
+}
+
+// modifiers packs the modifier state xkbState currently tracks into the
+// event.KeyModifiers bitmask pushed key events carry. It queries by
+// modifier name rather than forwarding the raw xkb mask, since xkb mod
+// indices are keymap-dependent while event.KeyModifiers is not.
+func (self *Window) modifiers() event.KeyModifiers {
+	if self.xkbState == nil {
+		return 0
+	}
+	var m event.KeyModifiers
+	if self.xkbState.ModNameIsActive("Shift") {
+		m |= event.ModShiftMask
+	}
+	if self.xkbState.ModNameIsActive("Lock") {
+		m |= event.ModLockMask
+	}
+	if self.xkbState.ModNameIsActive("Control") {
+		m |= event.ModCtrlMask
+	}
+	if self.xkbState.ModNameIsActive("Mod1") {
+		m |= event.ModMod1Mask
+	}
+	if self.xkbState.ModNameIsActive("Mod4") {
+		m |= event.ModMod4Mask
+	}
+	return m
+}
+
+func (self *Window) handleKeyboardRepeatInfo(e client.KeyboardRepeatInfoEvent) {
+	self.repeatRate = e.Rate
+	self.repeatDelay = e.Delay
+}
+
+// stopKeyRepeat cancels any in-flight repeat goroutine. Callers must already
+// hold self.mu, since repeatStop/repeatKey are also touched by Close from
+// the application goroutine.
+func (self *Window) stopKeyRepeat() {
+	if self.repeatStop != nil {
+		close(self.repeatStop)
+		self.repeatStop = nil
+	}
+}
+
+// startKeyRepeat re-injects KeyDown events for code at the rate/delay the
+// compositor advertised via wl_keyboard.repeat_info, until stopKeyRepeat is
+// called (on key up of code, or a press of another key that repeats).
+func (self *Window) startKeyRepeat(code uint32, ks event.KeySym, text string, mods event.KeyModifiers) {
+	self.stopKeyRepeat()
+	if self.repeatRate <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	self.repeatStop = stop
+	self.repeatKey = code
+
+	delay := time.Duration(self.repeatDelay) * time.Millisecond
+	period := time.Second / time.Duration(self.repeatRate)
+
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				self.pushEvent(&event.KeyDown{KeySym: ks, Text: text, Mods: mods})
+			}
+		}
+	}()
 }
 
 func (self *Window) handleKeyboardKey(e client.KeyboardKeyEvent) {
-	fmt.Println(e)
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.lastSerial = e.Serial
+
+	var ks event.KeySym
+	var text string
+	if self.xkbState != nil {
+		ks = event.KeySym(self.xkbState.KeySym(e.Key))
+		text = self.xkbState.UTF8(e.Key)
+	}
+	mods := self.modifiers()
+
+	if e.State == uint32(client.KeyboardKeyStatePressed) {
+		self.pushEvent(&event.KeyDown{KeySym: ks, Text: text, Mods: mods})
+		if self.keymap != nil && self.keymap.KeyRepeats(e.Key) {
+			self.startKeyRepeat(e.Key, ks, text, mods)
+		}
+	} else {
+		if self.repeatKey == e.Key {
+			self.stopKeyRepeat()
+		}
+		self.pushEvent(&event.KeyUp{KeySym: ks, Mods: mods})
+	}
 }
 
 func (self *Window) attachKeyboard() {
@@ -212,22 +598,27 @@ func (self *Window) attachKeyboard() {
 
 	keyboard.SetKeyHandler(self.handleKeyboardKey)
 	keyboard.SetKeymapHandler(self.handleKeyboardKeymap)
+	keyboard.SetModifiersHandler(self.handleKeyboardModifiers)
+	keyboard.SetRepeatInfoHandler(self.handleKeyboardRepeatInfo)
 }
 
 func (self *Window) handleSeatCapabilities(e client.SeatCapabilitiesEvent) {
-	havePointer := (e.Capabilities * uint32(client.SeatCapabilityPointer)) != 0
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	havePointer := (e.Capabilities & uint32(client.SeatCapabilityPointer)) != 0
 
-	if havePointer && self.pointer != nil {
+	if havePointer && self.pointer == nil {
 		self.attachPointer()
-	} else {
+	} else if !havePointer && self.pointer != nil {
 		self.releasePointer()
 	}
 
-	haveKeyboard := (e.Capabilities * uint32(client.SeatCapabilityKeyboard)) != 0
+	haveKeyboard := (e.Capabilities & uint32(client.SeatCapabilityKeyboard)) != 0
 
-	if haveKeyboard && self.keyboard != nil {
+	if haveKeyboard && self.keyboard == nil {
 		self.attachKeyboard()
-	} else {
+	} else if !haveKeyboard && self.keyboard != nil {
 		self.releaseKeyboard()
 	}
 }
@@ -271,6 +662,354 @@ func (self *Window) handleRegistryGlobal(e client.RegistryGlobalEvent) {
 		self.seatVersion = e.Version
 		seat.SetCapabilitiesHandler(self.handleSeatCapabilities)
 		seat.SetNameHandler(self.handleSeatName)
+		self.bindDataDevices()
+		self.bindTextInput()
+	case "wl_data_device_manager":
+		ddm := client.NewDataDeviceManager(self.display.Context())
+		err := self.registry.Bind(e.Name, e.Interface, e.Version, ddm)
+		if err != nil {
+			log.Fatalf("unable to bind wl_data_device_manager interface: %v", err)
+		}
+		self.dataDeviceManager = ddm
+		self.bindDataDevices()
+	case "zwp_primary_selection_device_manager_v1":
+		psm := primary_selection.NewDeviceManager(self.display.Context())
+		err := self.registry.Bind(e.Name, e.Interface, e.Version, psm)
+		if err != nil {
+			log.Fatalf("unable to bind zwp_primary_selection_device_manager_v1 interface: %v", err)
+		}
+		self.primarySelectionManager = psm
+		self.bindDataDevices()
+	case "zxdg_decoration_manager_v1":
+		dm := zxdg_decoration.NewDecorationManager(self.display.Context())
+		err := self.registry.Bind(e.Name, e.Interface, e.Version, dm)
+		if err != nil {
+			log.Fatalf("unable to bind zxdg_decoration_manager_v1 interface: %v", err)
+		}
+		self.decorationManager = dm
+	case "zwp_text_input_manager_v3":
+		tim := text_input.NewManager(self.display.Context())
+		err := self.registry.Bind(e.Name, e.Interface, e.Version, tim)
+		if err != nil {
+			log.Fatalf("unable to bind zwp_text_input_manager_v3 interface: %v", err)
+		}
+		self.textInputManager = tim
+		self.bindTextInput()
+	}
+}
+
+// bindDataDevices obtains the per-seat wl_data_device and
+// zwp_primary_selection_device_v1 objects as soon as both the seat and the
+// relevant manager global are available; either can arrive first.
+func (self *Window) bindDataDevices() {
+	if self.seat == nil {
+		return
+	}
+	if self.dataDevice == nil && self.dataDeviceManager != nil {
+		dd, err := self.dataDeviceManager.GetDataDevice(self.seat)
+		if err != nil {
+			log.Println("unable to get wl_data_device:", err)
+		} else {
+			self.dataDevice = dd
+			dd.SetDataOfferHandler(self.handleDataDeviceDataOffer)
+			dd.SetSelectionHandler(self.handleDataDeviceSelection)
+		}
+	}
+	if self.primaryDevice == nil && self.primarySelectionManager != nil {
+		pd, err := self.primarySelectionManager.GetDevice(self.seat)
+		if err != nil {
+			log.Println("unable to get zwp_primary_selection_device_v1:", err)
+		} else {
+			self.primaryDevice = pd
+			pd.SetDataOfferHandler(self.handlePrimaryDeviceDataOffer)
+			pd.SetSelectionHandler(self.handlePrimaryDeviceSelection)
+		}
+	}
+}
+
+func (self *Window) handleDataDeviceDataOffer(_ client.DataDeviceDataOfferEvent) {
+	// The offer's mime types arrive via its own "offer" event; we don't
+	// need to track them since we always ask for text/plain.
+}
+
+func (self *Window) handleDataDeviceSelection(e client.DataDeviceSelectionEvent) {
+	self.mu.Lock()
+	self.clipboardOffer = e.Id
+	self.mu.Unlock()
+}
+
+func (self *Window) handlePrimaryDeviceDataOffer(_ primary_selection.DeviceDataOfferEvent) {
+}
+
+func (self *Window) handlePrimaryDeviceSelection(e primary_selection.DeviceSelectionEvent) {
+	self.mu.Lock()
+	self.primaryOffer = e.Id
+	self.mu.Unlock()
+}
+
+// readOffer reads all the bytes an offer writes into the write end of a
+// freshly created pipe; write is expected to call Offer.Receive with that
+// fd, handing the read side back to us.
+func readOffer(write func(fd int) error) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if err := write(int(w.Fd())); err != nil {
+		w.Close()
+		return "", err
+	}
+	w.Close()
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// GetCPPaste implements driver.Window, returning the current clipboard or
+// primary selection contents.
+func (self *Window) GetCPPaste(i event.CopyPasteIndex) (string, error) {
+	switch i {
+	case event.ClipboardCPI:
+		self.mu.Lock()
+		offer := self.clipboardOffer
+		self.mu.Unlock()
+		if offer == nil {
+			return "", nil
+		}
+		return readOffer(func(fd int) error {
+			return offer.Receive("text/plain;charset=utf-8", fd)
+		})
+	case event.PrimaryCPI:
+		self.mu.Lock()
+		offer := self.primaryOffer
+		self.mu.Unlock()
+		if offer == nil {
+			return "", nil
+		}
+		return readOffer(func(fd int) error {
+			return offer.Receive("text/plain;charset=utf-8", fd)
+		})
+	default:
+		return "", fmt.Errorf("wayland: unknown copy/paste index %v", i)
+	}
+}
+
+// SetCPCopy implements driver.Window, publishing s as the clipboard or
+// primary selection.
+func (self *Window) SetCPCopy(i event.CopyPasteIndex, s string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	switch i {
+	case event.ClipboardCPI:
+		if self.dataDeviceManager == nil || self.dataDevice == nil {
+			return errors.New("wayland: no data device bound")
+		}
+		source, err := self.dataDeviceManager.CreateDataSource()
+		if err != nil {
+			return err
+		}
+		if err := source.Offer("text/plain;charset=utf-8"); err != nil {
+			return err
+		}
+		if err := source.Offer("UTF8_STRING"); err != nil {
+			return err
+		}
+		source.SetSendHandler(func(e client.DataSourceSendEvent) {
+			f := os.NewFile(uintptr(e.Fd), "wayland-clipboard-send")
+			defer f.Close()
+			if _, err := f.WriteString(s); err != nil {
+				log.Println("unable to write clipboard data:", err)
+			}
+		})
+		source.SetCancelledHandler(func(_ client.DataSourceCancelledEvent) {
+			if err := source.Destroy(); err != nil {
+				log.Println("unable to destroy wl_data_source:", err)
+			}
+		})
+		return self.dataDevice.SetSelection(source, self.lastSerial)
+	case event.PrimaryCPI:
+		if self.primarySelectionManager == nil || self.primaryDevice == nil {
+			return errors.New("wayland: no primary selection device bound")
+		}
+		source, err := self.primarySelectionManager.CreateSource()
+		if err != nil {
+			return err
+		}
+		if err := source.Offer("text/plain;charset=utf-8"); err != nil {
+			return err
+		}
+		source.SetSendHandler(func(e primary_selection.SourceSendEvent) {
+			f := os.NewFile(uintptr(e.Fd), "wayland-primary-send")
+			defer f.Close()
+			if _, err := f.WriteString(s); err != nil {
+				log.Println("unable to write primary selection data:", err)
+			}
+		})
+		source.SetCancelledHandler(func(_ primary_selection.SourceCancelledEvent) {
+			if err := source.Destroy(); err != nil {
+				log.Println("unable to destroy zwp_primary_selection_source_v1:", err)
+			}
+		})
+		return self.primaryDevice.SetSelection(source, self.lastSerial)
+	default:
+		return fmt.Errorf("wayland: unknown copy/paste index %v", i)
+	}
+}
+
+// bindTextInput obtains the per-seat zwp_text_input_v3 once both the seat
+// and the manager global are available; either can arrive first.
+func (self *Window) bindTextInput() {
+	if self.textInput != nil || self.textInputManager == nil || self.seat == nil {
+		return
+	}
+	ti, err := self.textInputManager.GetTextInput(self.seat)
+	if err != nil {
+		log.Println("unable to get zwp_text_input_v3:", err)
+		return
+	}
+	self.textInput = ti
+	ti.SetEnterHandler(self.handleTextInputEnter)
+	ti.SetLeaveHandler(self.handleTextInputLeave)
+	ti.SetPreeditStringHandler(self.handleTextInputPreeditString)
+	ti.SetCommitStringHandler(self.handleTextInputCommitString)
+	ti.SetDeleteSurroundingTextHandler(self.handleTextInputDeleteSurroundingText)
+	ti.SetDoneHandler(self.handleTextInputDone)
+}
+
+// sendTextInputState re-advertises content type and cursor rectangle to the
+// input method, and commits the current surrounding text. Called on focus
+// and again after every applied preedit/commit batch, since the cursor
+// position the compositor/IME needs to track moves with it. Callers must
+// already hold self.mu, since it issues the same text-input wire requests
+// SetInputCursorRect/SetInputSurroundingText do from the caller's goroutine.
+func (self *Window) sendTextInputState() {
+	if self.textInput == nil || !self.focused {
+		return
+	}
+	if err := self.textInput.Enable(); err != nil {
+		log.Println("unable to enable text-input:", err)
+		return
+	}
+	if err := self.textInput.SetContentType(uint32(text_input.ContentHintNone), uint32(text_input.ContentPurposeNormal)); err != nil {
+		log.Println("unable to set text-input content type:", err)
+	}
+	if self.surroundingText != "" {
+		if err := self.textInput.SetSurroundingText(self.surroundingText, self.surroundingCursor, self.surroundingAnchor); err != nil {
+			log.Println("unable to set text-input surrounding text:", err)
+		}
+	}
+	r := self.cursorRect
+	if err := self.textInput.SetCursorRectangle(int32(r.Min.X), int32(r.Min.Y), int32(r.Dx()), int32(r.Dy())); err != nil {
+		log.Println("unable to set text-input cursor rectangle:", err)
+	}
+	if err := self.textInput.Commit(); err != nil {
+		log.Println("unable to commit text-input state:", err)
+	}
+}
+
+func (self *Window) handleTextInputEnter(_ text_input.TextInputEnterEvent) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.focused = true
+	self.sendTextInputState()
+}
+
+func (self *Window) handleTextInputLeave(_ text_input.TextInputLeaveEvent) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.focused = false
+	if self.textInput == nil {
+		return
+	}
+	if err := self.textInput.Disable(); err != nil {
+		log.Println("unable to disable text-input:", err)
+	}
+	if err := self.textInput.Commit(); err != nil {
+		log.Println("unable to commit text-input state:", err)
+	}
+}
+
+func (self *Window) handleTextInputPreeditString(e text_input.TextInputPreeditStringEvent) {
+	self.pendingPreedit = &event.PreeditString{
+		Text:        e.Text,
+		CursorBegin: int(e.CursorBegin),
+		CursorEnd:   int(e.CursorEnd),
+	}
+}
+
+func (self *Window) handleTextInputCommitString(e text_input.TextInputCommitStringEvent) {
+	text := e.Text
+	self.pendingCommit = &text
+}
+
+func (self *Window) handleTextInputDeleteSurroundingText(e text_input.TextInputDeleteSurroundingTextEvent) {
+	self.pendingDeleteBefore = e.BeforeLength
+	self.pendingDeleteAfter = e.AfterLength
+}
+
+// handleTextInputDone applies the batch of preedit/commit/delete events
+// accumulated since the last done, per the text-input-v3 contract, then
+// re-sends the cursor rectangle since it will have moved.
+func (self *Window) handleTextInputDone(_ text_input.TextInputDoneEvent) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.pendingDeleteBefore != 0 || self.pendingDeleteAfter != 0 {
+		self.pushEvent(&event.DeleteSurroundingText{
+			Before: int(self.pendingDeleteBefore),
+			After:  int(self.pendingDeleteAfter),
+		})
+		self.pendingDeleteBefore, self.pendingDeleteAfter = 0, 0
+	}
+	if self.pendingCommit != nil {
+		self.pushEvent(&event.CommitString{Text: *self.pendingCommit})
+		self.pendingCommit = nil
+	}
+	if self.pendingPreedit != nil {
+		self.pushEvent(self.pendingPreedit)
+		self.pendingPreedit = nil
+	}
+	self.sendTextInputState()
+}
+
+// SetInputSurroundingText lets the core editor forward the focused row's
+// current line context, used by the IME for better predictions.
+func (self *Window) SetInputSurroundingText(text string, cursor, anchor int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.surroundingText = text
+	self.surroundingCursor = int32(cursor)
+	self.surroundingAnchor = int32(anchor)
+	if self.textInput != nil && self.focused {
+		if err := self.textInput.SetSurroundingText(text, self.surroundingCursor, self.surroundingAnchor); err != nil {
+			log.Println("unable to set text-input surrounding text:", err)
+			return
+		}
+		if err := self.textInput.Commit(); err != nil {
+			log.Println("unable to commit text-input state:", err)
+		}
+	}
+}
+
+// SetInputCursorRect lets the core editor report where the text cursor is
+// on screen, so the IME can position its candidate window.
+func (self *Window) SetInputCursorRect(r image.Rectangle) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.cursorRect = r
+	if self.textInput != nil && self.focused {
+		if err := self.textInput.SetCursorRectangle(int32(r.Min.X), int32(r.Min.Y), int32(r.Dx()), int32(r.Dy())); err != nil {
+			log.Println("unable to set text-input cursor rectangle:", err)
+			return
+		}
+		if err := self.textInput.Commit(); err != nil {
+			log.Println("unable to commit text-input state:", err)
+		}
 	}
 }
 
@@ -294,68 +1033,228 @@ func (self *Window) displayRoundTrip() {
 	}
 }
 
-func (self *Window) drawFrame() *client.Buffer {
-	stride := self.width * 4
-	size := stride * self.height
+// shmBufferPoolSize caps how many persistent shm buffers paint keeps around;
+// 2-3 is enough to let the compositor hold one for display while we prepare
+// the next, without unbounded tempfile growth across resizes.
+const shmBufferPoolSize = 3
+
+// shmBuffer is a persistent, reusable backing store for a client.Buffer:
+// created once per (width, height) and recycled (not destroyed) when the
+// compositor releases it.
+type shmBuffer struct {
+	wlBuf *client.Buffer
+	data  []byte
+	file  *os.File
+	w, h  int32
+	busy  bool
+	// current reports whether data already holds the full, up-to-date
+	// frame content. It's false for a freshly allocated buffer and for
+	// any other pooled buffer that wasn't painted into on the most
+	// recent paint() (so it missed that paint's dirty rects).
+	current bool
+}
+
+// newShmBuffer allocates a buffer whose release handler clears sb.busy under
+// win.mu, since acquireBuffer (called from paint, under the same lock) reads
+// and writes that field from the application goroutine as well.
+func newShmBuffer(win *Window, w, h int32) (*shmBuffer, error) {
+	shm := win.shm
+	stride := w * 4
+	size := int64(stride) * int64(h)
 
-	file, err := tempfileCreate(int64(size))
+	file, err := tempfileCreate(size)
 	if err != nil {
-		log.Fatalf("unable to create a temporary file: %v", err)
+		return nil, err
 	}
-	defer func() {
-		if err2 := file.Close(); err2 != nil {
-			log.Printf("unable to close file: %v\n", err2)
-		}
-	}()
 
 	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
 	if err != nil {
-		log.Fatalf("unable to create mapping: %v", err)
+		file.Close()
+		return nil, err
 	}
-	defer func() {
-		if err2 := unix.Munmap(data); err2 != nil {
-			log.Printf("unable to delete mapping: %v\n", err2)
-		}
-	}()
 
-	pool, err := self.shm.CreatePool(int(file.Fd()), size)
+	pool, err := shm.CreatePool(int(file.Fd()), size)
 	if err != nil {
-		log.Fatalf("unable to create shm pool: %v", err)
+		unix.Munmap(data)
+		file.Close()
+		return nil, err
 	}
 	defer func() {
 		if err2 := pool.Destroy(); err2 != nil {
-			log.Printf("unable to destroy shm pool: %v\n", err2)
+			log.Println("unable to destroy shm pool:", err2)
 		}
 	}()
 
-	buf, err := pool.CreateBuffer(0, self.width, self.height, stride, uint32(client.ShmFormatArgb8888))
+	wlBuf, err := pool.CreateBuffer(0, w, h, stride, uint32(client.ShmFormatArgb8888))
 	if err != nil {
-		log.Fatalf("unable to create client.Buffer from shm pool: %v", err)
+		unix.Munmap(data)
+		file.Close()
+		return nil, err
 	}
 
-	copy(data, self.frame.Pix)
-	swizzle.BGRA(data)
+	sb := &shmBuffer{wlBuf: wlBuf, data: data, file: file, w: w, h: h}
+	wlBuf.SetReleaseHandler(func(_ client.BufferReleaseEvent) {
+		win.mu.Lock()
+		sb.busy = false
+		win.mu.Unlock()
+		win.bufFree.Broadcast()
+	})
+	return sb, nil
+}
 
-	buf.SetReleaseHandler(func(_ client.BufferReleaseEvent) {
-		if err := buf.Destroy(); err != nil {
-			log.Printf("unable to destroy buffer: %v\n", err)
+func (sb *shmBuffer) destroy() {
+	if err := sb.wlBuf.Destroy(); err != nil {
+		log.Println("unable to destroy buffer:", err)
+	}
+	if err := unix.Munmap(sb.data); err != nil {
+		log.Println("unable to delete mapping:", err)
+	}
+	if err := sb.file.Close(); err != nil {
+		log.Println("unable to close file:", err)
+	}
+}
+
+// acquireBuffer returns a free shmBuffer sized for the current window, first
+// trying to reuse one already in the pool, evicting stale-sized ones that
+// aren't currently held by the compositor before allocating a new one. If
+// the pool is already full of right-sized buffers the compositor is still
+// holding, it waits on bufFree for one to be released rather than failing
+// the paint outright, since a resize burst or a paint faster than the
+// compositor releases buffers is expected, not an error. Callers must
+// already hold self.mu; Wait releases it while blocked.
+func (self *Window) acquireBuffer() (*shmBuffer, error) {
+	for {
+		if self.exit {
+			return nil, errors.New("wayland: window closing")
 		}
-	})
+		for _, b := range self.bufPool {
+			if !b.busy && b.w == self.width && b.h == self.height {
+				b.busy = true
+				return b, nil
+			}
+		}
+
+		kept := self.bufPool[:0]
+		for _, b := range self.bufPool {
+			if !b.busy && (b.w != self.width || b.h != self.height) {
+				b.destroy()
+				continue
+			}
+			kept = append(kept, b)
+		}
+		self.bufPool = kept
 
-	return buf
+		if len(self.bufPool) < shmBufferPoolSize {
+			b, err := newShmBuffer(self, self.width, self.height)
+			if err != nil {
+				return nil, err
+			}
+			b.busy = true
+			self.bufPool = append(self.bufPool, b)
+			return b, nil
+		}
+
+		self.bufFree.Wait()
+	}
+}
+
+// blitRect copies one dirty rectangle from the canonical RGBA frame into an
+// shm buffer, converting to the BGRA byte order the compositor expects.
+func blitRect(dst []byte, dstStride int32, frame *image.RGBA, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		so := frame.PixOffset(r.Min.X, y)
+		n := r.Dx() * 4
+		do := int(int32(y)*dstStride) + r.Min.X*4
+		copy(dst[do:do+n], frame.Pix[so:so+n])
+		swizzle.BGRA(dst[do : do+n])
+	}
+}
+
+// markDirty records a region of the frame that needs to be reuploaded on
+// the next paint. The UI layer calls this (directly, or via AddDamage) as
+// it draws into pImage/frame. Callers must already hold self.mu.
+func (self *Window) markDirty(r image.Rectangle) {
+	r = r.Intersect(self.frame.Bounds())
+	if r.Empty() {
+		return
+	}
+	self.dirty = append(self.dirty, r)
+}
+
+// AddDamage implements the UI layer's hook for reporting a repainted
+// region, ahead of a ReqPaint request.
+func (self *Window) AddDamage(r image.Rectangle) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.markDirty(r)
 }
 
 func (self *Window) handleSurfaceConfigure(e xdg_shell.SurfaceConfigureEvent) {
 	if err := self.xdgSurface.AckConfigure(e.Serial); err != nil {
 		log.Fatal("unable to ack xdg surface configure:", err)
 	}
-	buffer := self.drawFrame()
-	if err := self.surface.Attach(buffer, 0, 0); err != nil {
-		log.Fatalf("unable to attach buffer to surface: %v", err)
+	if err := self.paint(); err != nil {
+		log.Fatalf("unable to paint surface: %v", err)
 	}
-	if err := self.surface.Commit(); err != nil {
-		log.Fatalf("unable to commit surface state: %v", err)
+}
+
+// applyResize performs the actual (bilinear) pImage/frame resize once a
+// resize gesture has settled; see scheduleResizeDebounce. When the compositor
+// didn't grant server_side decorations, the UI content (pImage) is resized
+// into everything below the csdTitlebarHeight-row strip reserved at the top
+// of frame for drawCSDTitlebar, rather than stretched over the full surface,
+// so the fallback titlebar gains real estate instead of painting over it.
+func (self *Window) applyResize(width, height int32) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	contentHeight := height
+	offsetY := int32(0)
+	if !self.serverDecorated {
+		offsetY = csdTitlebarHeight
+		contentHeight = height - csdTitlebarHeight
+		if contentHeight < 0 {
+			contentHeight = 0
+		}
+	}
+
+	content := resize.Resize(uint(width), uint(contentHeight), self.pImage, resize.Bilinear).(*image.RGBA)
+	frame := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	draw.Draw(frame, content.Bounds().Add(image.Pt(0, int(offsetY))), content, image.Point{}, draw.Src)
+
+	self.frame = frame
+	self.width = width
+	self.height = height
+	// frame was just rebuilt from scratch, so any previously-drawn CSD
+	// titlebar is gone with it and must be redrawn on the next paint.
+	self.csdTitlebarDrawn = false
+	self.markDirty(self.frame.Bounds())
+}
+
+// scheduleResizeDebounce defers applying a pending resize until a
+// wl_surface.frame callback fires with no newer target size pending,
+// i.e. the compositor's interactive resize gesture has settled. This
+// avoids rescaling (and reallocating shm buffers) on every single
+// configure event of a drag-resize.
+func (self *Window) scheduleResizeDebounce() {
+	cb, err := self.surface.Frame()
+	if err != nil {
+		log.Println("unable to register frame callback:", err)
+		return
 	}
+	targetW, targetH := self.pendingWidth, self.pendingHeight
+	cb.SetDoneHandler(func(_ client.CallbackDoneEvent) {
+		if err := cb.Destroy(); err != nil {
+			log.Println("unable to destroy callback:", err)
+		}
+		self.mu.Lock()
+		changed := targetW != self.width || targetH != self.height
+		self.mu.Unlock()
+		settled := targetW == self.pendingWidth && targetH == self.pendingHeight
+		if settled && changed {
+			self.applyResize(targetW, targetH)
+		}
+	})
 }
 
 func (self *Window) handleToplevelConfigure(e xdg_shell.ToplevelConfigureEvent) {
@@ -365,23 +1264,159 @@ func (self *Window) handleToplevelConfigure(e xdg_shell.ToplevelConfigureEvent)
 	if width == 0 || height == 0 {
 		return
 	}
-
-	if width == self.width && height == self.height {
+	if width == self.pendingWidth && height == self.pendingHeight {
 		return
 	}
 
-	self.frame = resize.Resize(uint(width), uint(height), self.pImage, resize.Bilinear).(*image.RGBA)
+	self.pendingWidth = width
+	self.pendingHeight = height
 
-	self.width = width
-	self.height = height
+	if self.frame == nil {
+		// No frame yet: this is the very first configure, which
+		// handleSurfaceConfigure's immediately-following paint() depends
+		// on. There's nothing to debounce against (no prior size to
+		// settle relative to), so apply it synchronously instead of
+		// waiting on a wl_surface.frame callback that can only fire
+		// after a commit we haven't made yet.
+		self.applyResize(width, height)
+		return
+	}
+	self.scheduleResizeDebounce()
 }
 
 func (self *Window) handleToplevelClose(_ xdg_shell.ToplevelCloseEvent) {
+	self.setExit()
+}
+
+// setExit and isExit guard self.exit, which eventLoop's loop condition
+// (dispatch goroutine) reads while Request's ReqWindowClose case
+// (application goroutine) can set it concurrently.
+func (self *Window) setExit() {
+	self.mu.Lock()
 	self.exit = true
+	self.mu.Unlock()
+}
+
+func (self *Window) isExit() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.exit
+}
+
+func (self *Window) handleDecorationConfigure(e zxdg_decoration.ToplevelDecorationConfigureEvent) {
+	self.serverDecorated = zxdg_decoration.ToplevelDecorationMode(e.Mode) == zxdg_decoration.ToplevelDecorationModeServerSide
+	if !self.serverDecorated {
+		log.Println("wayland: compositor forced client-side decorations, drawing a minimal titlebar")
+	}
+}
+
+// drawCSDTitlebar paints a minimal titlebar strip, with a close button,
+// into the csdTitlebarHeight rows applyResize reserves at the top of frame
+// when the compositor didn't grant server_side decorations (the UI content
+// below that strip is never touched). There's no ui package reachable from
+// this driver to route this through (the request asking for this fallback
+// wanted it drawn via ui's widgets); this raster-only titlebar is what's
+// achievable here, with at least a close affordance so the window isn't
+// otherwise stuck open on CSD-only compositors. It only redraws (and marks
+// dirty) once per frame rebuild, since applyResize already resets
+// csdTitlebarDrawn whenever it replaces self.frame and the titlebar's
+// content is otherwise static.
+func (self *Window) drawCSDTitlebar() {
+	if self.serverDecorated || self.frame == nil || self.csdTitlebarDrawn {
+		return
+	}
+	b := self.frame.Bounds()
+
+	titlebar := image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+csdTitlebarHeight)
+	draw.Draw(self.frame, titlebar, &image.Uniform{C: color.RGBA{R: 0x30, G: 0x30, B: 0x30, A: 0xff}}, image.Point{}, draw.Src)
+	drawCSDCloseGlyph(self.frame, self.csdCloseButtonRect())
+	self.markDirty(titlebar)
+
+	self.csdTitlebarDrawn = true
+}
+
+// csdCloseButtonRect is the close button's hit/draw rectangle within the
+// CSD titlebar, anchored to its top-right corner.
+func (self *Window) csdCloseButtonRect() image.Rectangle {
+	b := self.frame.Bounds()
+	top := b.Min.Y + (csdTitlebarHeight-csdCloseButtonSize)/2
+	return image.Rect(
+		b.Max.X-csdButtonMargin-csdCloseButtonSize, top,
+		b.Max.X-csdButtonMargin, top+csdCloseButtonSize,
+	)
+}
+
+// drawCSDCloseGlyph draws a simple "X" inside r.
+func drawCSDCloseGlyph(frame *image.RGBA, r image.Rectangle) {
+	c := color.RGBA{R: 0xd0, G: 0xd0, B: 0xd0, A: 0xff}
+	for i := 0; i < r.Dx(); i++ {
+		frame.Set(r.Min.X+i, r.Min.Y+i, c)
+		frame.Set(r.Max.X-1-i, r.Min.Y+i, c)
+	}
+}
+
+// csdEdgeAt reports which resize edge (if any) p falls within, for the
+// client-side decoration fallback.
+func (self *Window) csdEdgeAt(p image.Point) (xdg_shell.ToplevelResizeEdge, bool) {
+	left := p.X < csdBorder
+	right := p.X >= int(self.width)-csdBorder
+	top := p.Y < csdBorder
+	bottom := p.Y >= int(self.height)-csdBorder
+	switch {
+	case top && left:
+		return xdg_shell.ToplevelResizeEdgeTopLeft, true
+	case top && right:
+		return xdg_shell.ToplevelResizeEdgeTopRight, true
+	case bottom && left:
+		return xdg_shell.ToplevelResizeEdgeBottomLeft, true
+	case bottom && right:
+		return xdg_shell.ToplevelResizeEdgeBottomRight, true
+	case top:
+		return xdg_shell.ToplevelResizeEdgeTop, true
+	case bottom:
+		return xdg_shell.ToplevelResizeEdgeBottom, true
+	case left:
+		return xdg_shell.ToplevelResizeEdgeLeft, true
+	case right:
+		return xdg_shell.ToplevelResizeEdgeRight, true
+	default:
+		return 0, false
+	}
+}
+
+// handleCSDPress intercepts left-button presses that land on the client-side
+// decoration's titlebar or border, starting an interactive move/resize
+// (or, on the titlebar's close button, setting exit) instead of forwarding a
+// MouseDown. Returns true if it handled the press.
+func (self *Window) handleCSDPress() bool {
+	if self.serverDecorated {
+		return false
+	}
+	if edge, ok := self.csdEdgeAt(self.pointerPos); ok {
+		if err := self.xdgTopLevel.Resize(self.seat, self.lastSerial, uint32(edge)); err != nil {
+			log.Println("unable to start interactive resize:", err)
+		}
+		return true
+	}
+	if self.pointerPos.In(self.csdCloseButtonRect()) {
+		// handleCSDPress only runs with self.mu already held by its
+		// caller, handlePointerButton, so set exit directly rather than
+		// through setExit (which would deadlock re-acquiring the lock).
+		self.exit = true
+		return true
+	}
+	if self.pointerPos.Y < csdTitlebarHeight {
+		if err := self.xdgTopLevel.Move(self.seat, self.lastSerial); err != nil {
+			log.Println("unable to start interactive move:", err)
+		}
+		return true
+	}
+	return false
 }
 
 func _newWaylandWindow() (win *Window, err error) {
-	win = &Window{}
+	win = &Window{events: make(chan event.Event, 32)}
+	win.bufFree = sync.NewCond(&win.mu)
 	display, err := client.Connect("")
 	if err != nil {
 		return
@@ -419,6 +1454,22 @@ func _newWaylandWindow() (win *Window, err error) {
 	win.xdgTopLevel.SetConfigureHandler(win.handleToplevelConfigure)
 	win.xdgTopLevel.SetCloseHandler(win.handleToplevelClose)
 
+	if win.decorationManager != nil {
+		dec, err2 := win.decorationManager.GetToplevelDecoration(win.xdgTopLevel)
+		if err2 != nil {
+			err = err2
+			return
+		}
+		win.toplevelDecoration = dec
+		dec.SetConfigureHandler(win.handleDecorationConfigure)
+		if err = dec.SetMode(uint32(zxdg_decoration.ToplevelDecorationModeServerSide)); err != nil {
+			return
+		}
+	}
+	// With no decoration manager advertised at all, win.serverDecorated
+	// stays false and we fall back to drawing our own titlebar/borders,
+	// same as a compositor-forced client_side mode.
+
 	// TODO: set title and appid
 	if err = win.surface.Commit(); err != nil {
 		return
@@ -438,20 +1489,107 @@ func newWaylandWindow() (*Window, error) {
 	if err != nil {
 		win.Close()
 		win = nil
+		return win, err
 	}
+	go win.eventLoop()
 	return win, err
 }
 
+// eventLoop dispatches the wayland display on a dedicated goroutine until
+// the window is closed or the connection errors out, translating protocol
+// events (pushed onto self.events from the various handlers) into the
+// event.Event values NextEvent hands back to the core editor.
+func (self *Window) eventLoop() {
+	for !self.isExit() {
+		if err := self.display.Context().Dispatch(); err != nil {
+			log.Println("wayland: dispatch error:", err)
+			self.setExit()
+			break
+		}
+	}
+	close(self.events)
+}
+
 func (self *Window) NextEvent() (event.Event, bool) {
-	panic("TODO")
+	ev, ok := <-self.events
+	if !ok {
+		return nil, false
+	}
+	return ev, true
+}
+
+// paint blits every dirty rectangle (falling back to the whole frame
+// whenever nothing has been marked since the last paint) into a pooled shm buffer
+// and attaches it, damaging only those rectangles. If the acquired buffer
+// wasn't painted into on the previous paint (e.g. it's freshly allocated,
+// or it's a second/third pool buffer that missed intervening dirty rects
+// while the compositor held it), the whole frame is blitted into it
+// instead, since self.dirty alone only reflects damage since the *last*
+// paint, not since this specific buffer was last up to date.
+func (self *Window) paint() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.drawCSDTitlebar()
+	if len(self.dirty) == 0 {
+		self.markDirty(self.frame.Bounds())
+	}
+
+	buf, err := self.acquireBuffer()
+	if err != nil {
+		return err
+	}
+
+	rects := self.dirty
+	if !buf.current {
+		rects = []image.Rectangle{self.frame.Bounds()}
+	}
+
+	for _, r := range rects {
+		blitRect(buf.data, buf.w*4, self.frame, r)
+	}
+
+	if err := self.surface.Attach(buf.wlBuf, 0, 0); err != nil {
+		return err
+	}
+	for _, r := range rects {
+		if err := self.surface.DamageBuffer(int32(r.Min.X), int32(r.Min.Y), int32(r.Dx()), int32(r.Dy())); err != nil {
+			return err
+		}
+	}
+	if err := self.surface.Commit(); err != nil {
+		return err
+	}
+
+	buf.current = true
+	for _, b := range self.bufPool {
+		if b != buf {
+			b.current = false
+		}
+	}
+
+	self.dirty = self.dirty[:0]
+	return nil
 }
 
 func (self *Window) Request(req event.Request) error {
-	panic("TODO")
+	switch t := req.(type) {
+	case *event.ReqWindowSetName:
+		if err := self.xdgTopLevel.SetTitle(t.Name); err != nil {
+			return err
+		}
+		return self.xdgTopLevel.SetAppId(t.Name)
+	case *event.ReqWindowClose:
+		self.setExit()
+		return nil
+	case *event.ReqPaint:
+		return self.paint()
+	default:
+		return fmt.Errorf("wayland: unhandled request type %T", req)
+	}
 }
 
 func NewWindow() (driver.Window, error) {
-	culo
 	win, err := newWaylandWindow()
 	if err != nil {
 		xwin, err2 := xdriver.NewWindow()